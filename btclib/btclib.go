@@ -0,0 +1,228 @@
+// Package btclib provides helpers for building Bitcoin-like blocks: hex and
+// integer encodings, target bits decoding, coinbase transaction construction
+// and merkle root computation.
+package btclib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// UintToLeHex encodes the lowest width bytes of x as little endian hex
+func UintToLeHex(x, width uint64) string {
+	var (
+		i   uint64
+		hex string
+	)
+	for i = 0; i < width; i++ {
+		hex += fmt.Sprintf("%02x", uint8(x>>uint(8*i)))
+	}
+	return hex
+}
+
+// BinToHex encodes bytes as a hex string
+func BinToHex(bytes []byte) string {
+	return hex.EncodeToString(bytes)
+}
+
+// DecodeTargetBits decodes compact target bits hex into a 32-byte big
+// endian target. As in Bitcoin Core, negative targets (mantissa sign bit
+// set) and zero targets are rejected: no hash can reach them.
+func DecodeTargetBits(bits string) (target []byte, err error) {
+	a, err := hex.DecodeString(bits)
+	if err != nil {
+		return nil, err
+	}
+	if len(a) < 2 || len(a) > 32 || a[0] > 32 {
+		return nil, errors.New("invalid target bits: " + bits)
+	}
+	if a[1]&0x80 != 0 {
+		return nil, errors.New("negative target bits: " + bits)
+	}
+
+	target = make([]byte, 32)
+
+	// Bits: 1b0404cb
+	// 1b -> right shift of (0x1b) bytes
+	// 0404cb -> value
+
+	//copy value to a target slice to position at '32 - shift'
+	copy(target[32-a[0]:], a[1:])
+
+	for _, x := range target {
+		if x != 0 {
+			return
+		}
+	}
+	return nil, errors.New("zero target bits: " + bits)
+}
+
+// EncodeCoinbaseHeight encodes the block height as the script push required
+// at the start of the coinbase scriptSig (BIP34)
+func EncodeCoinbaseHeight(n uint32) []byte {
+	const minSize = 1
+	bytes := []byte{1}
+
+	for n > 127 {
+		bytes[0] += 1
+		bytes = append(bytes, byte(n%256))
+		n /= 256
+	}
+	bytes = append(bytes, byte(n))
+
+	for len(bytes) < minSize+1 {
+		bytes = append(bytes, 0)
+		bytes[0] += 1
+	}
+
+	return bytes
+}
+
+// AddrToHash160 returns the hash160 hex of a base58check address
+func AddrToHash160(address string) string {
+	hash := base58.Decode(address)
+	hashHex := BinToHex(hash)
+	return hashHex[2 : len(hashHex)-8]
+}
+
+// UintToVarIntHex encodes x as a variable length integer hex
+func UintToVarIntHex(x uint64) string {
+	switch {
+	case x < 0xfd:
+		return fmt.Sprintf("%02x", x)
+	case x <= 0xffff:
+		return "fd" + UintToLeHex(x, 2)
+	case x <= 0xffffffff:
+		return "fe" + UintToLeHex(x, 4)
+	default:
+		return "ff" + UintToLeHex(x, 8)
+	}
+}
+
+// MaxCoinbaseScriptSize is the consensus limit for the coinbase scriptSig size
+const MaxCoinbaseScriptSize = 100
+
+// FitCoinbaseSig truncates the coinbase signature so that the scriptSig with
+// the height push and an extra nonce of the given size stays within
+// MaxCoinbaseScriptSize. It returns whether the signature was truncated.
+func FitCoinbaseSig(sig []byte, height uint32, extraNonceSize int) (
+	[]byte, bool) {
+	size := extraNonceSize
+	if height != 0 {
+		size += len(EncodeCoinbaseHeight(height))
+	}
+	if size+len(sig) <= MaxCoinbaseScriptSize {
+		return sig, false
+	}
+	if size >= MaxCoinbaseScriptSize {
+		return nil, len(sig) > 0
+	}
+	return sig[:MaxCoinbaseScriptSize-size], true
+}
+
+// MakeCoinBaseTx builds the coinbase transaction hex. The scriptSig is the
+// height push (if height is not 0), followed by the optional coinbase
+// signature hex and the extra nonce hex.
+func MakeCoinBaseTx(coinbaseExtraNonce string, coinbaseSig string,
+	address string, value uint64, height uint32) string {
+
+	var coinbaseScript string
+	if height != 0 {
+		coinbaseScript = BinToHex(EncodeCoinbaseHeight(height))
+	}
+	coinbaseScript += coinbaseSig + coinbaseExtraNonce
+
+	// Create a pubkey script
+	// OP_DUP OP_HASH160 <len to push> <pubkey> OP_EQUALVERIFY OP_CHECKSIG
+	pubkeyScript := "76a914" + AddrToHash160(address) + "88ac"
+
+	tx := ""
+	// version
+	tx += "01000000"
+	// in-counter
+	tx += "01"
+	// input[0] prev hash
+	tx += "0000000000000000000000000000000000000000000000000000000000000000"
+	// input[0] prev seqnum
+	tx += "ffffffff"
+	// input[0] script len
+	tx += UintToVarIntHex(uint64(len(coinbaseScript)) / 2)
+	// input[0] script
+	tx += coinbaseScript
+	// input[0] seqnum
+	tx += "ffffffff"
+	// out-counter
+	tx += "01"
+	// output[0] value (little endian)
+	tx += UintToLeHex(value, 8)
+	// output[0] script len
+	tx += UintToVarIntHex(uint64(len(pubkeyScript)) / 2)
+	// output[0] script
+	tx += pubkeyScript
+	// lock-time
+	tx += "00000000"
+
+	return tx
+}
+
+// HexToBin decodes a hex string, it panics on invalid input
+func HexToBin(hexStr string) []byte {
+	bytes, err := hex.DecodeString(hexStr)
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
+
+// ReverseBytes reverses bytes in place and returns them
+func ReverseBytes(bytes []byte) []byte {
+	for i, j := 0, len(bytes)-1; i < j; i, j = i+1, j-1 {
+		bytes[i], bytes[j] = bytes[j], bytes[i]
+	}
+	return bytes
+}
+
+// DoubleSHA256 returns the SHA-256 of the SHA-256 of data, the hash of
+// transactions and merkle tree nodes
+func DoubleSHA256(data []byte) []byte {
+	h1 := sha256.Sum256(data)
+	h2 := sha256.Sum256(h1[:])
+	return h2[:]
+}
+
+// ComputeMerkleRoot computes the double SHA-256 merkle root of the big
+// endian transaction hashes hex. The root is returned in the little endian
+// byte order used in the block header.
+func ComputeMerkleRoot(txsHashesHex []string) []byte {
+	var txsHashes [][]byte
+	for _, txHashHex := range txsHashesHex {
+		// Reverse the hash from big endian to little endian
+		txHash := ReverseBytes(HexToBin(txHashHex))
+		txsHashes = append(txsHashes, txHash)
+	}
+	// Reusable buffer for the concatenation of two 32-byte hashes
+	concat := make([]byte, 64)
+	for len(txsHashes) > 1 {
+		var newTxsHashes [][]byte
+		if len(txsHashes)%2 != 0 {
+			txsHashes = append(txsHashes, txsHashes[len(txsHashes)-1])
+		}
+		for {
+			copy(concat[:32], txsHashes[0])
+			copy(concat[32:], txsHashes[1])
+			concatHash := DoubleSHA256(concat)
+			newTxsHashes = append(newTxsHashes, concatHash)
+			if len(txsHashes) > 2 {
+				txsHashes = txsHashes[2:]
+			} else {
+				break
+			}
+		}
+		txsHashes = newTxsHashes
+	}
+	return txsHashes[0]
+}
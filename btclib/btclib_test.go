@@ -0,0 +1,253 @@
+package btclib
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestUintToLeHex(t *testing.T) {
+	tests := []struct {
+		x     uint64
+		width uint64
+		want  string
+	}{
+		{0x1a, 1, "1a"},
+		{0x1a2b, 2, "2b1a"},
+		{0x1a2b3c4d, 4, "4d3c2b1a"},
+		{0x1a2b3c4d5e6f7a8b, 8, "8b7a6f5e4d3c2b1a"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("_%d", i), func(t *testing.T) {
+			if got := UintToLeHex(tt.x, tt.width); got != tt.want {
+				t.Errorf("UintToLeHex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinToHex(t *testing.T) {
+	tests := []struct {
+		bytes []byte
+		want  string
+	}{
+		{[]byte{0, 1, 0xab, 0xcd, 'A'}, "0001abcd41"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("_%d", i), func(t *testing.T) {
+			if got := BinToHex(tt.bytes); got != tt.want {
+				t.Errorf("BinToHex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTargetBits(t *testing.T) {
+	tests := []struct {
+		bits       string
+		wantTarget []byte
+		wantErr    bool
+	}{
+		{"1a01aa3d", HexToBin(
+			"00000000000001aa3d0000000000000000000000000000000000000000000000"), false},
+		{"207fffff", HexToBin(
+			"7fffff0000000000000000000000000000000000000000000000000000000000"), false},
+		{"", nil, true},
+		{"1a01aa3", nil, true},
+		{"2101aa3d", nil, true},
+		// Zero mantissa, zero exponent and a mantissa shifted out
+		{"03000000", nil, true},
+		{"00ffffff", nil, true},
+		{"01003456", nil, true},
+		// Sign bit set
+		{"1d800000", nil, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("_%d", i), func(t *testing.T) {
+			gotTarget, err := DecodeTargetBits(tt.bits)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeTargetBits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(gotTarget, tt.wantTarget) {
+				t.Errorf("DecodeTargetBits() = %v, want %v", gotTarget, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestUintToVarIntHex(t *testing.T) {
+	tests := []struct {
+		x    uint64
+		want string
+	}{
+		{0x1a, "1a"},
+		{0x1a2b, "fd2b1a"},
+		{0x1a2b3c, "fe3c2b1a00"},
+		{0x1a2b3c4d, "fe4d3c2b1a"},
+		{0x1a2b3c4d5e, "ff5e4d3c2b1a000000"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("_%d", i), func(t *testing.T) {
+			if got := UintToVarIntHex(tt.x); got != tt.want {
+				t.Errorf("UintToVarIntHex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeCoinBaseTx(t *testing.T) {
+	want := "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff2503ef98030400001059124d696e656420627920425443204775696c640800000037000011caffffffff01a0635c95000000001976a91427a1f12771de5cc3b73941664b2537c15316be4388ac00000000"
+
+	coinbaseScript := "03ef98030400001059124d696e656420627920425443204775696c640800000037000011ca"
+	address := "14cZMQk89mRYQkDEj8Rn25AnGoBi5H6uer"
+	value := uint64(2505860000)
+
+	got := MakeCoinBaseTx(coinbaseScript, "", address, value, 0)
+
+	if want != got {
+		t.Log("want:", want)
+		t.Log(" got:", got)
+		t.Fatal("want not equal to got")
+	}
+}
+
+func TestMakeCoinBaseTxWithSig(t *testing.T) {
+	// Height 300000 push, "/test/" signature, extra nonce 01000000
+	wantScript := "03e09304" + "2f746573742f" + "01000000"
+	want := "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff" +
+		"0e" + wantScript + "ffffffff01a0635c95000000001976a91427a1f12771de5cc3b73941664b2537c15316be4388ac00000000"
+
+	got := MakeCoinBaseTx("01000000", BinToHex([]byte("/test/")),
+		"14cZMQk89mRYQkDEj8Rn25AnGoBi5H6uer", 2505860000, 300000)
+
+	if want != got {
+		t.Log("want:", want)
+		t.Log(" got:", got)
+		t.Fatal("want not equal to got")
+	}
+}
+
+func TestFitCoinbaseSig(t *testing.T) {
+	long := make([]byte, 120)
+	tests := []struct {
+		name          string
+		sig           []byte
+		height        uint32
+		wantLen       int
+		wantTruncated bool
+	}{
+		{"fits", []byte("/mypool/"), 300000, 8, false},
+		{"truncated with height", long, 300000, 92, true},
+		{"truncated without height", long, 0, 96, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := FitCoinbaseSig(tt.sig, tt.height, 4)
+			if len(got) != tt.wantLen || truncated != tt.wantTruncated {
+				t.Errorf("FitCoinbaseSig() = %d bytes, %v, want %d bytes, %v",
+					len(got), truncated, tt.wantLen, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestDoubleSHA256(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"", "5df6e0e2761359d30a8275058e299fcc0381534545f55cf43e41983f5d4c9456"},
+		{"hello", "9595c9df90075148eb06860365df33584b75bff782a510c6cd4883a419833d50"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("_%d", i), func(t *testing.T) {
+			if got := BinToHex(DoubleSHA256([]byte(tt.data))); got != tt.want {
+				t.Errorf("DoubleSHA256() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeMerkleRoot(t *testing.T) {
+	type args struct {
+		txHashes []string
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{ // block testnet 00000000000001624cce24d8b32cb09ad5432a7173b905a06d048547f241a0b0
+			name: "even hashes count",
+			args: args{
+				txHashes: []string{
+					"decb39cfcc1d6b8e0155d14b8923dfc1b6cfe65bcc19a9f9e136b7a65c2ffb9d",
+					"3e52c0e53c2c12b8d51cae5700273273f3968e7d9edc2c6e1093a74ba6fe7865",
+					"0f7035239f5bd6759b5bfdf8f7cbfce0e7b382e105fa55af20a3994f439524c0",
+					"d07fb5461d4a455270c06a6370708f6282256c2608a1937e54cd5db1f272657d",
+				},
+			},
+			want: "07ecfbfa6214b1261daf058dbd226091d26acf8511c88f21b660a901cbc8179b",
+		},
+		{ // block testnet 00000000000000be13b52a46edd0e959e7785d569feb1b42ffc6eee7ae7caafa
+			name: "odd hashes count",
+			args: args{
+				txHashes: []string{
+					"1f4f9136b20069249115d55c843ec18acc1889b862cde134386e14396de7bdb3",
+					"d731233d670af128e0a77dae8aa2b998f7515d0febe9aeb3ddfca0fff256e5b1",
+					"874afe1c0e0dd76fcd4cd4f4a923a803d119e8612c70e6599c4e9cd98bb54084",
+				},
+			},
+			want: "1489b66849671c4758d2b90d411e64b8e7ea5681ace1f60b9e053b94c7aef231",
+		},
+		{ // block regtest 63b2a02cce7888f359c82413f66de5cd4ad109fb91be7a19493df62551491975
+			name: "single transaction",
+			args: args{
+				txHashes: []string{
+					"aef812ae5d301be4bad82cd1881a7e0d735e081cb91f725c1fbc1ece83aba23c",
+				},
+			},
+			want: "aef812ae5d301be4bad82cd1881a7e0d735e081cb91f725c1fbc1ece83aba23c",
+		},
+		{ // sha256 of single bytes 0x00..0x06, folded over three levels
+			name: "multiple levels",
+			args: args{
+				txHashes: []string{
+					"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+					"4bf5122f344554c53bde2ebb8cd2b7e3d1600ad631c385a5d7cce23c7785459a",
+					"dbc1b4c900ffe48d575b5da5c638040125f65db0fe3e24494b76ea986457d986",
+					"084fed08b978af4d7d196a7446a86b58009e636b611db16211b65a9aadff29c5",
+					"e52d9c508c502347344d8c07ad91cbd6068afc75ff6292f062a09ca381c89e71",
+					"e77b9a9ae9e30b0dbdb6f510a264ef9de781501d7b6b92ae89eb059c5ab743db",
+					"67586e98fad27da0b9968bc039a1ef34c939b9b8e523a8bef89d478608c5ecf6",
+				},
+			},
+			want: "5f8e960f7c3fea621ac6512d95102420ff436dc0f3b3a05bf022a55f2cc1dc41",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ComputeMerkleRoot(tt.args.txHashes); BinToHex(ReverseBytes(got)) != tt.want {
+				t.Errorf("ComputeMerkleRoot() = %v, want %v", BinToHex(ReverseBytes(got)), tt.want)
+			}
+		})
+	}
+}
+
+func FuzzDecodeTargetBits(f *testing.F) {
+	for _, seed := range []string{"1a01aa3d", "207fffff", "1d00ffff", "", "00", "2101aa3d", "zz", "03000000", "1d800000"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, bits string) {
+		target, err := DecodeTargetBits(bits)
+		if err != nil {
+			return
+		}
+		if len(target) != 32 {
+			t.Errorf("DecodeTargetBits(%q) returned %d bytes", bits, len(target))
+		}
+		if reflect.DeepEqual(target, make([]byte, len(target))) {
+			t.Errorf("DecodeTargetBits(%q) returned a zero target", bits)
+		}
+	})
+}
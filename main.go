@@ -1,18 +1,26 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"math"
+	"math/big"
 	"os"
+	"runtime"
 	"time"
 
 	"golang.org/x/crypto/scrypt"
 
-	"github.com/btcsuite/btcutil/base58"
 	"github.com/ybbus/jsonrpc"
+
+	"github.com/noname-project/btcminer/btclib"
 )
 
 const (
@@ -29,11 +37,68 @@ const (
 
 	btc = "btc"
 	ltc = "ltc"
+)
+
+// btc or ltc, selected with -coin
+var miningCurrency = btc
+
+// Coin presets accepted by -coin. A preset selects the hash algorithm, the
+// default RPC URL and the payout address of the currency; -rpc-url and the
+// other RPC flags still override the preset.
+var coins = map[string]string{
+	"bitcoin":  btc,
+	"btc":      btc,
+	"litecoin": ltc,
+	"ltc":      ltc,
+}
+
+var coin = flag.String("coin", "bitcoin",
+	"coin to mine: bitcoin (btc, double SHA-256) or litecoin (ltc, scrypt)")
+
+// Build information, set with -ldflags "-X main.version=... -X main.commit=...
+// -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+var versionMode = flag.Bool("version", false,
+	"print build information as JSON and exit")
+
+var debug = flag.Bool("debug", false, "print debug output")
+
+var checkMode = flag.Bool("check", false,
+	"fetch a block template to validate RPC connectivity and credentials, "+
+		"print it and exit without mining")
+
+var miningDuration = flag.Duration("duration", 0,
+	"stop mining after the given duration, 0 means mine until a block is solved")
+
+var (
+	hashrateUnit = flag.String("hashrate-unit", "KH",
+		"hash rate display unit: auto, H, KH, MH, GH or TH")
+	hashratePrecision = flag.Int("hashrate-precision", 4,
+		"number of decimals in the displayed hash rate")
+)
 
-	// btc or ltc
-	miningCurrency = btc
+// RPC connection settings, empty flags fall back to the environment and then
+// to the built-in defaults
+var (
+	rpcURLFlag = flag.String("rpc-url", "",
+		"node RPC URL (env BTCMINER_RPC_URL)")
+	rpcUserFlag = flag.String("rpc-user", "",
+		"node RPC user (env BTCMINER_RPC_USER)")
+	rpcPasswordFlag = flag.String("rpc-password", "",
+		"node RPC password (env BTCMINER_RPC_PASSWORD)")
 )
 
+var coinbaseSig = flag.String("coinbase-sig", "",
+	"text embedded into the coinbase scriptSig after the height, e.g. /mypool/")
+
+var userAgent = flag.String("user-agent", "",
+	"User-Agent header sent with RPC requests, empty for the HTTP client default")
+
 type Transaction struct {
 	Hash    string `json:"hash"`
 	TxID    string `json:"txid"`
@@ -61,9 +126,25 @@ type Block struct {
 	Capabilities      []string      `json:"capabilities"`
 	Mutable           []string      `json:"mutable"`
 
-	Hash       string `json:"-"`
-	Nonce      uint32 `json:"-"`
-	MerkleRoot []byte `json:"-"`
+	Hash       string  `json:"-"`
+	Nonce      uint32  `json:"-"`
+	MerkleRoot []byte  `json:"-"`
+	Difficulty float64 `json:"-"`
+}
+
+// Difficulty 1 target, 0x00000000ffff0000...0000
+var diff1Target = new(big.Int).Lsh(big.NewInt(0xffff), 208)
+
+// flagOrEnv returns the flag value if set, otherwise the value of the
+// environment variable if set, otherwise the default value
+func flagOrEnv(flagValue, envKey, defaultValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if envValue := os.Getenv(envKey); envValue != "" {
+		return envValue
+	}
+	return defaultValue
 }
 
 func rpc(method string, params ...interface{}) (
@@ -77,12 +158,21 @@ func rpc(method string, params ...interface{}) (
 	default:
 		panic("unsupported currency: " + miningCurrency)
 	}
+	rpcURL = flagOrEnv(*rpcURLFlag, "BTCMINER_RPC_URL", rpcURL)
+	user := flagOrEnv(*rpcUserFlag, "BTCMINER_RPC_USER", rpcUser)
+	password := flagOrEnv(*rpcPasswordFlag, "BTCMINER_RPC_PASSWORD",
+		rpcPassword)
+
+	headers := map[string]string{
+		"Authorization": "Basic " + base64.StdEncoding.EncodeToString(
+			[]byte(user+":"+password)),
+	}
+	if *userAgent != "" {
+		headers["User-Agent"] = *userAgent
+	}
 
 	client := jsonrpc.NewClientWithOpts(rpcURL, &jsonrpc.RPCClientOpts{
-		CustomHeaders: map[string]string{
-			"Authorization": "Basic " + base64.StdEncoding.EncodeToString(
-				[]byte(rpcUser+":"+rpcPassword)),
-		},
+		CustomHeaders: headers,
 	})
 
 	res, err := client.Call(method, params...)
@@ -109,79 +199,95 @@ func rpcGetBlockTemplate() (Block, error) {
 		return b, err
 	}
 
+	err = validateBlockTemplate(b)
+	if err != nil {
+		return b, fmt.Errorf("invalid block template: %v", err)
+	}
+
 	return b, nil
 }
 
-func rpcSubmitBlock(block string) error {
-	res, err := rpc("submitblock", block)
+func validateHexField(name, value string, size int) error {
+	bytes, err := hex.DecodeString(value)
 	if err != nil {
-		fmt.Println(err)
+		return fmt.Errorf("%s is not a hex string: %v", name, err)
 	}
-	if res.Result != nil {
-		resStr, err := res.GetString()
-		if err != nil {
-			fmt.Println("Failed to get response string:", err)
-		}
-		fmt.Println("Response string:", resStr)
-	} else {
-		fmt.Println("Result is nil, submitted")
+	if size > 0 && len(bytes) != size {
+		return fmt.Errorf("%s must be %d bytes, got %d", name, size, len(bytes))
 	}
-	return err
+	return nil
 }
 
-func uintToLeHex(x, width uint64) string {
-	var (
-		i   uint64
-		hex string
-	)
-	for i = 0; i < width; i++ {
-		hex += fmt.Sprintf("%02x", uint8(x>>uint(8*i)))
+func validateBlockTemplate(b Block) error {
+	if err := validateHexField("previousblockhash", b.PreviousBlockHash,
+		32); err != nil {
+		return err
 	}
-	return hex
-}
-
-func binToHex(bytes []byte) string {
-	return hex.EncodeToString(bytes)
-}
-
-func decodeTargetBits(bits string) (target []byte) {
-	a, err := hex.DecodeString(bits)
-	if err != nil {
-		return target
+	if err := validateHexField("bits", b.Bits, 4); err != nil {
+		return err
 	}
-	if len(a) < 2 || len(a) > 32 || a[0] > 32 {
-		panic("invalid target hex string")
+	if _, err := btclib.DecodeTargetBits(b.Bits); err != nil {
+		return err
 	}
+	for i, tx := range b.Transactions {
+		if err := validateHexField(fmt.Sprintf("transaction %d hash", i),
+			tx.Hash, 32); err != nil {
+			return err
+		}
+		if err := validateHexField(fmt.Sprintf("transaction %d data", i),
+			tx.Data, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	target = make([]byte, 32)
-
-	// Bits: 1b0404cb
-	// 1b -> right shift of (0x1b) bytes
-	// 0404cb -> value
+// BIP22 submitblock results for a block the node has already seen
+const (
+	submitResultDuplicate             = "duplicate"
+	submitResultDuplicateInvalid      = "duplicate-invalid"
+	submitResultDuplicateInconclusive = "duplicate-inconclusive"
+)
 
-	//copy value to a target slice to position at '32 - shift'
-	copy(target[32-a[0]:], a[1:])
+var errDuplicateBlock = errors.New("block rejected as duplicate")
 
-	return
+func isDuplicateSubmitResult(result string) bool {
+	switch result {
+	case submitResultDuplicate, submitResultDuplicateInvalid,
+		submitResultDuplicateInconclusive:
+		return true
+	default:
+		return false
+	}
 }
 
-func encodeCoinbaseHeight(n uint32) []byte {
-	const minSize = 1
-	bytes := []byte{1}
-
-	for n > 127 {
-		bytes[0] += 1
-		bytes = append(bytes, byte(n%256))
-		n /= 256
+// submitResultError converts a non-null BIP22 submitblock result into an
+// error, duplicates are reported as errDuplicateBlock
+func submitResultError(result string) error {
+	if isDuplicateSubmitResult(result) {
+		return fmt.Errorf("%w: %s", errDuplicateBlock, result)
 	}
-	bytes = append(bytes, byte(n))
+	return errors.New("block rejected: " + result)
+}
 
-	for len(bytes) < minSize+1 {
-		bytes = append(bytes, 0)
-		bytes[0] += 1
+func rpcSubmitBlock(block string) error {
+	res, err := rpc("submitblock", block)
+	if err != nil {
+		if rpcErr, ok := err.(*jsonrpc.RPCError); ok {
+			return fmt.Errorf("submit failed, code: %d, message: %s, data: %v",
+				rpcErr.Code, rpcErr.Message, rpcErr.Data)
+		}
+		return fmt.Errorf("submit failed: %v", err)
 	}
-
-	return bytes
+	if res.Result == nil {
+		fmt.Println("Result is nil, submitted")
+		return nil
+	}
+	resStr, err := res.GetString()
+	if err != nil {
+		return fmt.Errorf("failed to get response string: %v", err)
+	}
+	return submitResultError(resStr)
 }
 
 func reverseString(s string) string {
@@ -192,82 +298,20 @@ func reverseString(s string) string {
 	return string(runes)
 }
 
-func addrToHash160(address string) string {
-	hash := base58.Decode(address)
-	hashHex := binToHex(hash)
-	return hashHex[2 : len(hashHex)-8]
-}
-
-func uintToVarIntHex(x uint64) string {
-	switch {
-	case x < 0xfd:
-		return fmt.Sprintf("%02x", x)
-	case x <= 0xffff:
-		return "fd" + uintToLeHex(x, 2)
-	case x <= 0xffffffff:
-		return "fe" + uintToLeHex(x, 4)
-	default:
-		return "ff" + uintToLeHex(x, 8)
-	}
-}
-
-func makeCoinBaseTx(coinbaseExtraNonce string, address string, value uint64,
-	height uint32) string {
-
-	var coinbaseScript string
-	if height == 0 {
-		coinbaseScript = coinbaseExtraNonce
-	} else {
-		coinbaseScript = binToHex(encodeCoinbaseHeight(height)) + coinbaseExtraNonce
-	}
-
-	// Create a pubkey script
-	// OP_DUP OP_HASH160 <len to push> <pubkey> OP_EQUALVERIFY OP_CHECKSIG
-	pubkeyScript := "76a914" + addrToHash160(address) + "88ac"
-
-	tx := ""
-	// version
-	tx += "01000000"
-	// in-counter
-	tx += "01"
-	// input[0] prev hash
-	tx += "0000000000000000000000000000000000000000000000000000000000000000"
-	// input[0] prev seqnum
-	tx += "ffffffff"
-	// input[0] script len
-	tx += uintToVarIntHex(uint64(len(coinbaseScript)) / 2)
-	// input[0] script
-	tx += coinbaseScript
-	// input[0] seqnum
-	tx += "ffffffff"
-	// out-counter
-	tx += "01"
-	// output[0] value (little endian)
-	tx += uintToLeHex(value, 8)
-	// output[0] script len
-	tx += uintToVarIntHex(uint64(len(pubkeyScript)) / 2)
-	// output[0] script
-	tx += pubkeyScript
-	// lock-time
-	tx += "00000000"
-
-	return tx
-}
-
-func hexToBin(hexStr string) []byte {
-	bytes, err := hex.DecodeString(hexStr)
-	if err != nil {
-		panic(err)
-	}
-	return bytes
-}
-
 func computeBTCHash(data []byte) []byte {
 	h1 := sha256.Sum256(data)
 	h2 := sha256.Sum256(h1[:])
 	return h2[:]
 }
 
+// computeBTCHashInto writes the double SHA-256 of data into dst without
+// allocating
+func computeBTCHashInto(dst, data []byte) {
+	h1 := sha256.Sum256(data)
+	h2 := sha256.Sum256(h1[:])
+	copy(dst, h2[:])
+}
+
 func computeLTCHash(data []byte) []byte {
 	// https://litecoin.info/index.php/Scrypt
 	// Litecoin uses the following values for the call to scrypt:
@@ -294,47 +338,26 @@ func computeHash(data []byte) []byte {
 	}
 }
 
-func computeHashString(data string) string {
-	return binToHex(reverseBytes(computeHash(hexToBin(data))))
-}
-
-func reverseBytes(bytes []byte) []byte {
-	for i, j := 0, len(bytes)-1; i < j; i, j = i+1, j-1 {
-		bytes[i], bytes[j] = bytes[j], bytes[i]
+// computeHashInto writes the hash of data into the 32-byte dst. It is used
+// in the mining loop to avoid an allocation per hash where the hash
+// function allows it.
+func computeHashInto(dst, data []byte) {
+	switch miningCurrency {
+	case btc:
+		computeBTCHashInto(dst, data)
+	case ltc:
+		copy(dst, computeLTCHash(data))
+	default:
+		panic("unknown mining currency: " + miningCurrency)
 	}
-	return bytes
 }
 
-func computeMerkleRoot(txsHashesHex []string) []byte {
-	fmt.Println(txsHashesHex)
-	var txsHashes [][]byte
-	for _, txHashHex := range txsHashesHex {
-		// Reverse the hash from big endian to little endian
-		txHash := reverseBytes(hexToBin(txHashHex))
-		txsHashes = append(txsHashes, txHash)
-	}
-	for len(txsHashes) > 1 {
-		var newTxsHashes [][]byte
-		if len(txsHashes)%2 != 0 {
-			txsHashes = append(txsHashes, txsHashes[len(txsHashes)-1])
-		}
-		for {
-			h1 := txsHashes[0]
-			h2 := txsHashes[1]
-			concat := []byte{}
-			concat = append(concat, h1...)
-			concat = append(concat, h2...)
-			concatHash := computeHash(concat)
-			newTxsHashes = append(newTxsHashes, concatHash)
-			if len(txsHashes) > 2 {
-				txsHashes = txsHashes[2:]
-			} else {
-				break
-			}
-		}
-		txsHashes = newTxsHashes
-	}
-	return txsHashes[0]
+// computeTxHashString returns the big endian hex txid of the transaction
+// data hex. Transactions are hashed with double SHA-256 whatever the mining
+// currency, only the block header proof of work uses computeHash.
+func computeTxHashString(data string) string {
+	return btclib.BinToHex(btclib.ReverseBytes(
+		btclib.DoubleSHA256(btclib.HexToBin(data))))
 }
 
 func makeHeader(b Block) []byte {
@@ -346,7 +369,7 @@ func makeHeader(b Block) []byte {
 	header = append(header, versionBytes...)
 
 	// Previous block hash
-	header = append(header, reverseBytes(hexToBin(b.PreviousBlockHash))...)
+	header = append(header, btclib.ReverseBytes(btclib.HexToBin(b.PreviousBlockHash))...)
 
 	// Merkle root hash
 	header = append(header, b.MerkleRoot...)
@@ -357,7 +380,7 @@ func makeHeader(b Block) []byte {
 	header = append(header, timeBytes...)
 
 	// Target bits
-	header = append(header, reverseBytes(hexToBin(b.Bits))...)
+	header = append(header, btclib.ReverseBytes(btclib.HexToBin(b.Bits))...)
 
 	// Nonce
 	nonceBytes := make([]byte, 4)
@@ -369,7 +392,23 @@ func makeHeader(b Block) []byte {
 
 func computeBlockHeaderHash(header []byte) []byte {
 	hash := computeHash(header)
-	return reverseBytes(hash[:])
+	return btclib.ReverseBytes(hash[:])
+}
+
+// computeBlockHeaderHashInto is computeBlockHeaderHash writing into dst
+func computeBlockHeaderHashInto(dst, header []byte) {
+	computeHashInto(dst, header)
+	btclib.ReverseBytes(dst)
+}
+
+// checkHashLength verifies that the output of the hash function can be
+// compared byte by byte against the target
+func checkHashLength(hash func([]byte) []byte, target []byte) error {
+	if n := len(hash(make([]byte, 80))); n != len(target) {
+		return fmt.Errorf("hash length %d does not match target length %d",
+			n, len(target))
+	}
+	return nil
 }
 
 func checkBlockTarget(blockHash []byte, targetHash []byte) bool {
@@ -386,6 +425,138 @@ func checkBlockTarget(blockHash []byte, targetHash []byte) bool {
 	return false
 }
 
+// blockValidator re-verifies a solved block header against the big endian
+// target before the block is submitted, returning false to drop the block.
+// The default hashes the header without the hash functions of the mining
+// loop: crypto/sha256 through btclib for bitcoin, scrypt with the Litecoin
+// parameters spelled out for litecoin, and compares as integers. Tests, or
+// a second hash implementation, can replace it.
+var blockValidator = func(header []byte, target []byte) bool {
+	var hash []byte
+	switch miningCurrency {
+	case btc:
+		hash = btclib.DoubleSHA256(header)
+	case ltc:
+		var err error
+		hash, err = scrypt.Key(header, header, 1024, 1, 1, 32)
+		if err != nil {
+			return false
+		}
+	default:
+		panic("unsupported currency: " + miningCurrency)
+	}
+	hashInt := new(big.Int).SetBytes(btclib.ReverseBytes(hash))
+	return hashInt.Cmp(new(big.Int).SetBytes(target)) <= 0
+}
+
+// compactToTarget decodes the 4-byte compact target bits hex with math/big
+// into a 32-byte big endian target, independently from
+// btclib.DecodeTargetBits. Negative, zero and overflowing targets return nil.
+func compactToTarget(bits string) []byte {
+	b, err := hex.DecodeString(bits)
+	if err != nil || len(b) != 4 {
+		return nil
+	}
+	compact := binary.BigEndian.Uint32(b)
+	exponent := uint(compact >> 24)
+	if compact&0x00800000 != 0 {
+		return nil
+	}
+	target := big.NewInt(int64(compact & 0x007fffff))
+	if exponent <= 3 {
+		target.Rsh(target, 8*(3-exponent))
+	} else {
+		target.Lsh(target, 8*(exponent-3))
+	}
+	if target.Sign() == 0 || target.BitLen() > 256 {
+		return nil
+	}
+	return target.FillBytes(make([]byte, 32))
+}
+
+// validateSolvedBlock re-checks the block about to be submitted: the merkle
+// root of the header is recomputed from the transactions data and the
+// header is checked with blockValidator against the target of the bits.
+func validateSolvedBlock(block Block) bool {
+	if len(block.Transactions) == 0 {
+		return false
+	}
+	var txsHashesHex []string
+	for _, tx := range block.Transactions {
+		txHash := btclib.DoubleSHA256(btclib.HexToBin(tx.Data))
+		txsHashesHex = append(txsHashesHex,
+			btclib.BinToHex(btclib.ReverseBytes(txHash)))
+	}
+	header := makeHeader(block)
+	if !bytes.Equal(header[36:68], btclib.ComputeMerkleRoot(txsHashesHex)) {
+		return false
+	}
+	target := compactToTarget(block.Bits)
+	if target == nil {
+		return false
+	}
+	return blockValidator(header, target)
+}
+
+// submitSolvedBlock submits the solved block if it passes
+// validateSolvedBlock, and reports whether it was submitted
+func submitSolvedBlock(block Block) (bool, error) {
+	if !validateSolvedBlock(block) {
+		return false, nil
+	}
+	blockSubmission := makeBlockSubmission(block)
+	fmt.Println("Submiting:", blockSubmission)
+	return true, rpcSubmitBlock(blockSubmission)
+}
+
+// computeHashDifficulty returns the difficulty of a big endian hash relative
+// to the difficulty 1 target
+func computeHashDifficulty(hash []byte) float64 {
+	h := new(big.Int).SetBytes(hash)
+	if h.Sign() == 0 {
+		return 0
+	}
+	d, _ := new(big.Float).Quo(new(big.Float).SetInt(diff1Target),
+		new(big.Float).SetInt(h)).Float64()
+	return d
+}
+
+var hashrateUnits = []struct {
+	name  string
+	label string
+	scale float64
+}{
+	{"H", "hash/s", 1},
+	{"KH", "Khash/s", 1e3},
+	{"MH", "Mhash/s", 1e6},
+	{"GH", "Ghash/s", 1e9},
+	{"TH", "Thash/s", 1e12},
+}
+
+func validHashrateUnit(unit string) bool {
+	if unit == "auto" {
+		return true
+	}
+	for _, u := range hashrateUnits {
+		if u.name == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// formatHashrate formats a hash rate as "<unit>: <value>" in the given unit,
+// or in the largest unit keeping the value at or above 1 for "auto"
+func formatHashrate(hps float64, unit string, precision int) string {
+	u := hashrateUnits[0]
+	for _, x := range hashrateUnits {
+		if unit == "auto" && hps >= x.scale || unit == x.name {
+			u = x
+		}
+	}
+	return fmt.Sprintf("%s: %.*f", u.label, precision, hps/u.scale)
+}
+
 func computeHpsAverage(hps []float64) float64 {
 	if len(hps) == 0 {
 		return 0
@@ -397,7 +568,45 @@ func computeHpsAverage(hps []float64) float64 {
 	return sum / float64(len(hps))
 }
 
-func mineBlock(block Block) (Block, bool, float64) {
+// miningStats are the hashes computed while mining a block template, the
+// time spent and whether the whole extra nonce and nonce space was searched
+type miningStats struct {
+	Hashes    uint64
+	Elapsed   time.Duration
+	Exhausted bool
+}
+
+func (s miningStats) hashRate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Hashes) / s.Elapsed.Seconds()
+}
+
+// Search space limits of mineBlock, variables so tests can shrink them
+var (
+	maxExtraNonce uint64 = math.MaxUint32
+	maxNonce      uint64 = math.MaxUint32
+)
+
+// mineBlock mines the block template until a block is solved, a minute
+// passes since it started on the template, the deadline (if not zero) is
+// reached or the search space is exhausted. The returned stats cover the
+// whole template.
+func mineBlock(block Block, deadline time.Time) (Block, bool, miningStats,
+	error) {
+	var stats miningStats
+
+	// Refuse to mine without a valid target, otherwise every hash would be
+	// compared against an empty or zero target no hash can reach
+	targetHash, err := btclib.DecodeTargetBits(block.Bits)
+	if err != nil {
+		return block, false, stats, err
+	}
+	if err := checkHashLength(computeHash, targetHash); err != nil {
+		return block, false, stats, err
+	}
+
 	var address string
 	switch miningCurrency {
 	case btc:
@@ -411,20 +620,27 @@ func mineBlock(block Block) (Block, bool, float64) {
 	// Unshift empty transaction to create place for coinbase transaction
 	block.Transactions = append([]Transaction{{}}, block.Transactions...)
 
-	targetHash := decodeTargetBits(block.Bits)
+	sig, truncated := btclib.FitCoinbaseSig([]byte(*coinbaseSig), block.Height, 4)
+	if truncated {
+		fmt.Printf("Coinbase signature truncated to %d bytes: %q\n",
+			len(sig), sig)
+	}
+	coinbaseSigHex := btclib.BinToHex(sig)
 
-	startTime := time.Now()
+	templateStartTime := time.Now()
+	startTime := templateStartTime
 	hps := []float64{}
 
-	var extraNonce uint32 = 0
-	for extraNonce <= 0xffffffff {
+	// Both loops count in 64 bits so that the last 32-bit value is searched
+	// and the loops terminate instead of wrapping around to zero
+	for extraNonce := uint64(0); extraNonce <= maxExtraNonce; extraNonce++ {
 		var coinbaseTx Transaction
 
 		// Update the coinbase transaction with the extra nonce
-		coinbaseExtraNonce := uintToLeHex(uint64(extraNonce), 4)
-		coinbaseTx.Data = makeCoinBaseTx(coinbaseExtraNonce, address,
-			block.CoinBaseValue, block.Height)
-		coinbaseTx.Hash = computeHashString(coinbaseTx.Data)
+		coinbaseExtraNonce := btclib.UintToLeHex(extraNonce, 4)
+		coinbaseTx.Data = btclib.MakeCoinBaseTx(coinbaseExtraNonce, coinbaseSigHex,
+			address, block.CoinBaseValue, block.Height)
+		coinbaseTx.Hash = computeTxHashString(coinbaseTx.Data)
 
 		block.Transactions[0] = coinbaseTx
 
@@ -434,54 +650,65 @@ func mineBlock(block Block) (Block, bool, float64) {
 			txsHashesHex = append(txsHashesHex, tx.Hash)
 		}
 
-		block.MerkleRoot = computeMerkleRoot(txsHashesHex)
+		if *debug {
+			fmt.Println(txsHashesHex)
+		}
+		block.MerkleRoot = btclib.ComputeMerkleRoot(txsHashesHex)
 		block.Nonce = 0
 
 		blockHeader := makeHeader(block)
+		blockHash := make([]byte, 32)
 
-		var nonce uint32 = 0
-		for nonce <= 0xffffffff {
+		for n := uint64(0); n <= maxNonce; n++ {
+			nonce := uint32(n)
 			block.Nonce = nonce
 
 			// Update the block header with the new 32-bit nonce
 			binary.LittleEndian.PutUint32(blockHeader[76:], nonce)
 
-			//blockHash := computeHash(blockHeader)
-			blockHash := computeBlockHeaderHash(blockHeader)
+			computeBlockHeaderHashInto(blockHash, blockHeader)
+			stats.Hashes++
 
 			if checkBlockTarget(blockHash, targetHash) {
 				block.Nonce = nonce
-				block.Hash = binToHex(blockHash)
-				return block, true, computeHpsAverage(hps)
+				block.Hash = btclib.BinToHex(blockHash)
+				block.Difficulty = computeHashDifficulty(blockHash)
+				stats.Elapsed = time.Since(templateStartTime)
+				return block, true, stats, nil
 			}
 
 			if nonce > 0 && nonce%10000 == 0 {
 				elapsed := time.Now().Sub(startTime)
 				hps = append(hps, 10000/elapsed.Seconds())
-				if time.Now().Sub(startTime).Seconds() > 60 {
-					return block, false, computeHpsAverage(hps)
+				if time.Since(templateStartTime) > time.Minute ||
+					(!deadline.IsZero() && time.Now().After(deadline)) {
+					stats.Elapsed = time.Since(templateStartTime)
+					return block, false, stats, nil
+				}
+				fmt.Println("Average", formatHashrate(computeHpsAverage(hps),
+					*hashrateUnit, *hashratePrecision))
+				if *debug {
+					fmt.Printf("Nanoseconds per hash: %.1f\n",
+						float64(elapsed.Nanoseconds())/10000)
 				}
-				fmt.Printf("Average Khash/s: %.4f\n",
-					computeHpsAverage(hps)/1000)
 				startTime = time.Now()
 			}
-
-			nonce++
 		}
-		extraNonce++
 	}
 
-	return block, false, 0
+	stats.Elapsed = time.Since(templateStartTime)
+	stats.Exhausted = true
+	return block, false, stats, nil
 }
 
 func makeBlockSubmission(block Block) string {
 	subm := ""
 
 	// Block header
-	subm += binToHex(makeHeader(block))
+	subm += btclib.BinToHex(makeHeader(block))
 
 	// Number of transactions as varint
-	subm += uintToVarIntHex(uint64(len(block.Transactions)))
+	subm += btclib.UintToVarIntHex(uint64(len(block.Transactions)))
 
 	// Concatenated transactions data
 	for _, tx := range block.Transactions {
@@ -491,7 +718,107 @@ func makeBlockSubmission(block Block) string {
 	return subm
 }
 
+type buildInfo struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+func getBuildInfo() buildInfo {
+	return buildInfo{
+		Name:      "btcminer",
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+// describeBlockTemplate returns a one line summary of the block template,
+// the previous block hash is shortened to its last 16 hex digits since the
+// leading ones are zeros
+func describeBlockTemplate(b Block) string {
+	prevHash := b.PreviousBlockHash
+	if len(prevHash) > 16 {
+		prevHash = "..." + prevHash[len(prevHash)-16:]
+	}
+	return fmt.Sprintf("height: %d, previous block: %s, time: %d, bits: %s, "+
+		"transactions: %d", b.Height, prevHash, b.CurTime, b.Bits,
+		len(b.Transactions))
+}
+
+func checkBlockTemplate() error {
+	block, err := rpcGetBlockTemplate()
+	if err != nil {
+		return err
+	}
+
+	target, err := btclib.DecodeTargetBits(block.Bits)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Block template height:", block.Height)
+	fmt.Println("Previous block hash:", block.PreviousBlockHash)
+	fmt.Println("Target bits:", block.Bits)
+	fmt.Println("Target:", btclib.BinToHex(target))
+	fmt.Println("Coinbase value:", block.CoinBaseValue)
+	fmt.Println("Transactions:", len(block.Transactions))
+
+	return nil
+}
+
 func main() {
+	flag.Parse()
+
+	currency, ok := coins[*coin]
+	if !ok {
+		fmt.Println("Unsupported coin:", *coin)
+		os.Exit(2)
+	}
+	miningCurrency = currency
+
+	if *versionMode || flag.Arg(0) == "version" {
+		info, err := json.Marshal(getBuildInfo())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(string(info))
+		os.Exit(0)
+	}
+
+	if !validHashrateUnit(*hashrateUnit) {
+		fmt.Println("Invalid hash rate unit:", *hashrateUnit)
+		os.Exit(2)
+	}
+	if *hashratePrecision < 0 {
+		fmt.Println("Invalid hash rate precision:", *hashratePrecision)
+		os.Exit(2)
+	}
+
+	if *checkMode {
+		if err := checkBlockTemplate(); err != nil {
+			fmt.Println("Check failed:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Check passed")
+		os.Exit(0)
+	}
+
+	var deadline time.Time
+	if *miningDuration > 0 {
+		deadline = time.Now().Add(*miningDuration)
+	}
+	sessionStartTime := time.Now()
+	var sessionHashes uint64
+
 	for {
 		fmt.Println("Mining new block template...")
 
@@ -500,16 +827,49 @@ func main() {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+		fmt.Println("New block template,", describeBlockTemplate(block))
+		if *debug {
+			fmt.Printf("%+v\n", block)
+		}
+
+		minedBlock, mined, stats, err := mineBlock(block, deadline)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 
-		minedBlock, mined, hps := mineBlock(block)
+		fmt.Println("Average", formatHashrate(stats.hashRate(),
+			*hashrateUnit, *hashratePrecision))
+		sessionHashes += stats.Hashes
 
-		fmt.Printf("Average Khash/s: %.4f\n", hps/1000)
+		if stats.Exhausted {
+			fmt.Printf("Warning: extra nonce and nonce space exhausted for "+
+				"block template at height %d without solving it\n", block.Height)
+		}
 
 		if mined {
 			fmt.Println("Solved block! Block hash:", minedBlock.Hash)
-			blockSubmission := makeBlockSubmission(minedBlock)
-			fmt.Println("Submiting:", blockSubmission)
-			rpcSubmitBlock(blockSubmission)
+			fmt.Printf("Block hash difficulty: %.4f\n", minedBlock.Difficulty)
+			submitted, err := submitSolvedBlock(minedBlock)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if submitted {
+				os.Exit(0)
+			}
+			fmt.Println("Solved block failed validation, not submitting")
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			sessionStats := miningStats{
+				Hashes:  sessionHashes,
+				Elapsed: time.Since(sessionStartTime),
+			}
+			fmt.Println("Mining duration elapsed, stopping")
+			fmt.Println("Total hashes:", sessionStats.Hashes)
+			fmt.Println("Session average", formatHashrate(
+				sessionStats.hashRate(), *hashrateUnit, *hashratePrecision))
 			os.Exit(0)
 		}
 	}
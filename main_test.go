@@ -1,148 +1,577 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/noname-project/btcminer/btclib"
 )
 
-func Test_uintToLeHex(t *testing.T) {
+func Test_mineBlockWithoutTarget(t *testing.T) {
+	tests := []struct {
+		name string
+		bits string
+	}{
+		{"missing bits", ""},
+		{"zero target bits", "03000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, mined, _, err := mineBlock(Block{Bits: tt.bits}, time.Time{})
+			if err == nil {
+				t.Fatal("mineBlock() without a reachable target should return an error")
+			}
+			if mined {
+				t.Error("mineBlock() without a reachable target should not mine a block")
+			}
+		})
+	}
+}
+
+func Test_validateBlockTemplate(t *testing.T) {
+	valid := Block{
+		PreviousBlockHash: "000000000000000000000000000000000000000000000000000000000000000f",
+		Bits:              "207fffff",
+		Transactions: []Transaction{{
+			Hash: "aef812ae5d301be4bad82cd1881a7e0d735e081cb91f725c1fbc1ece83aba23c",
+			Data: "01000000",
+		}},
+	}
 	tests := []struct {
-		x     uint64
-		width uint64
-		want  string
+		name    string
+		modify  func(b *Block)
+		wantErr bool
 	}{
-		{0x1a, 1, "1a"},
-		{0x1a2b, 2, "2b1a"},
-		{0x1a2b3c4d, 4, "4d3c2b1a"},
-		{0x1a2b3c4d5e6f7a8b, 8, "8b7a6f5e4d3c2b1a"},
+		{"valid", func(b *Block) {}, false},
+		{"short previous hash", func(b *Block) { b.PreviousBlockHash = "0f" }, true},
+		{"non-hex previous hash", func(b *Block) { b.PreviousBlockHash = "zz" }, true},
+		{"short bits", func(b *Block) { b.Bits = "207fff" }, true},
+		{"missing bits", func(b *Block) { b.Bits = "" }, true},
+		{"bits exponent out of range", func(b *Block) { b.Bits = "21ffffff" }, true},
+		{"zero target bits", func(b *Block) { b.Bits = "03000000" }, true},
+		{"shifted out target bits", func(b *Block) { b.Bits = "01003456" }, true},
+		{"negative target bits", func(b *Block) { b.Bits = "1d800000" }, true},
+		{"short transaction hash", func(b *Block) {
+			b.Transactions = []Transaction{{Hash: "aef8", Data: "01000000"}}
+		}, true},
+		{"non-hex transaction data", func(b *Block) {
+			b.Transactions = []Transaction{{Hash: valid.Transactions[0].Hash, Data: "0"}}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := valid
+			tt.modify(&b)
+			if err := validateBlockTemplate(b); (err != nil) != tt.wantErr {
+				t.Errorf("validateBlockTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_computeHashDifficulty(t *testing.T) {
+	tests := []struct {
+		hash string
+		want float64
+	}{
+		{"00000000ffff0000000000000000000000000000000000000000000000000000", 1},
+		{"000000007fff8000000000000000000000000000000000000000000000000000", 2},
+		{"0000000000000000000000000000000000000000000000000000000000000000", 0},
 	}
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("_%d", i), func(t *testing.T) {
-			if got := uintToLeHex(tt.x, tt.width); got != tt.want {
-				t.Errorf("uintToLeHex() = %v, want %v", got, tt.want)
+			if got := computeHashDifficulty(btclib.HexToBin(tt.hash)); got != tt.want {
+				t.Errorf("computeHashDifficulty() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func Test_binToHex(t *testing.T) {
+func Test_flagOrEnv(t *testing.T) {
+	const envKey = "BTCMINER_TEST_FLAG_OR_ENV"
 	tests := []struct {
-		bytes []byte
-		want  string
+		name string
+		flag string
+		env  string
+		want string
 	}{
-		{[]byte{0, 1, 0xab, 0xcd, 'A'}, "0001abcd41"},
+		{"flag takes precedence", "flag", "env", "flag"},
+		{"env fallback", "", "env", "env"},
+		{"default fallback", "", "", "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envKey, tt.env)
+			if got := flagOrEnv(tt.flag, envKey, "default"); got != tt.want {
+				t.Errorf("flagOrEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_computeBlockHeaderHashInto(t *testing.T) {
+	header := make([]byte, 80)
+	header[0] = 1
+
+	want := computeBlockHeaderHash(header)
+	got := make([]byte, 32)
+	computeBlockHeaderHashInto(got, header)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("computeBlockHeaderHashInto() = %x, want %x", got, want)
+	}
+}
+
+func Benchmark_computeBlockHeaderHash(b *testing.B) {
+	header := make([]byte, 80)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		computeBlockHeaderHash(header)
+	}
+}
+
+func Benchmark_computeBlockHeaderHashInto(b *testing.B) {
+	header := make([]byte, 80)
+	hash := make([]byte, 32)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		computeBlockHeaderHashInto(hash, header)
+	}
+}
+
+func Test_formatHashrate(t *testing.T) {
+	tests := []struct {
+		hps       float64
+		unit      string
+		precision int
+		want      string
+	}{
+		{12345.6789, "KH", 4, "Khash/s: 12.3457"},
+		{12345.6789, "MH", 3, "Mhash/s: 0.012"},
+		{12345.6789, "H", 0, "hash/s: 12346"},
+		{12345.6789, "auto", 2, "Khash/s: 12.35"},
+		{2.5e9, "auto", 1, "Ghash/s: 2.5"},
+		{0.5, "auto", 1, "hash/s: 0.5"},
 	}
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("_%d", i), func(t *testing.T) {
-			if got := binToHex(tt.bytes); got != tt.want {
-				t.Errorf("binToHex() = %v, want %v", got, tt.want)
+			if got := formatHashrate(tt.hps, tt.unit, tt.precision); got != tt.want {
+				t.Errorf("formatHashrate() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func Test_decodeTargetBits(t *testing.T) {
+func Test_miningStats_hashRate(t *testing.T) {
 	tests := []struct {
-		bits       string
-		wantTarget []byte
+		stats miningStats
+		want  float64
 	}{
-		{"1a01aa3d", hexToBin(
-			"00000000000001aa3d0000000000000000000000000000000000000000000000")},
-		{"207fffff", hexToBin(
-			"7fffff0000000000000000000000000000000000000000000000000000000000")},
+		{miningStats{Hashes: 5000, Elapsed: 2 * time.Second}, 2500},
+		{miningStats{Hashes: 5000}, 0},
+		{miningStats{}, 0},
 	}
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("_%d", i), func(t *testing.T) {
-			if gotTarget := decodeTargetBits(tt.bits); !reflect.DeepEqual(gotTarget, tt.wantTarget) {
-				t.Errorf("decodeTargetBits() = %v, want %v", gotTarget, tt.wantTarget)
+			if got := tt.stats.hashRate(); got != tt.want {
+				t.Errorf("miningStats.hashRate() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func Test_uintToVarIntHex(t *testing.T) {
+func Test_makeHeader(t *testing.T) {
+	// Bitcoin genesis block
+	block := Block{
+		Version:           1,
+		PreviousBlockHash: "0000000000000000000000000000000000000000000000000000000000000000",
+		MerkleRoot: btclib.ReverseBytes(btclib.HexToBin(
+			"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")),
+		CurTime: 1231006505,
+		Bits:    "1d00ffff",
+		Nonce:   2083236893,
+	}
+	wantHeader := "01000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a" +
+		"29ab5f49" + "ffff001d" + "1dac2b7c"
+	wantHash := "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+
+	header := makeHeader(block)
+	if got := btclib.BinToHex(header); got != wantHeader {
+		t.Fatalf("makeHeader() = %v, want %v", got, wantHeader)
+	}
+	if got := btclib.BinToHex(computeBlockHeaderHash(header)); got != wantHash {
+		t.Errorf("computeBlockHeaderHash() = %v, want %v", got, wantHash)
+	}
+}
+
+func Test_checkHashLength(t *testing.T) {
+	target := make([]byte, 32)
+	shortHash := func(data []byte) []byte {
+		return computeHash(data)[:16]
+	}
+
+	if err := checkHashLength(computeHash, target); err != nil {
+		t.Errorf("checkHashLength() with 32-byte hash error = %v", err)
+	}
+	if err := checkHashLength(shortHash, target); err == nil {
+		t.Error("checkHashLength() with 16-byte hash should return an error")
+	}
+}
+
+func Test_describeBlockTemplate(t *testing.T) {
+	b := Block{
+		PreviousBlockHash: "000000000000000000076c036ff5119e5a5a74df77abf64203473074d8d2bd9b",
+		CurTime:           1535568045,
+		Height:            539245,
+		Bits:              "17272fbd",
+		Transactions:      make([]Transaction, 3),
+	}
+	want := "height: 539245, previous block: ...03473074d8d2bd9b, " +
+		"time: 1535568045, bits: 17272fbd, transactions: 3"
+	if got := describeBlockTemplate(b); got != want {
+		t.Errorf("describeBlockTemplate() = %v, want %v", got, want)
+	}
+}
+
+func Test_mineBlockSpaceExhausted(t *testing.T) {
+	defer func(extraNonce, nonce uint64) {
+		maxExtraNonce, maxNonce = extraNonce, nonce
+	}(maxExtraNonce, maxNonce)
+	maxExtraNonce, maxNonce = 1, 15
+
+	block := Block{
+		PreviousBlockHash: "0000000000000000000000000000000000000000000000000000000000000000",
+		// Target of 1, unreachable within the synthetic space
+		Bits:          "03000001",
+		Height:        1,
+		CoinBaseValue: 5000000000,
+	}
+	_, mined, stats, err := mineBlock(block, time.Time{})
+	if err != nil {
+		t.Fatal("mineBlock() error:", err)
+	}
+	if mined {
+		t.Error("mineBlock() should not solve a block with an unreachable target")
+	}
+	if !stats.Exhausted {
+		t.Error("mineBlock() should report the search space as exhausted")
+	}
+	// Extra nonces 0 and 1, nonces 0 to 15 each
+	if want := uint64(2 * 16); stats.Hashes != want {
+		t.Errorf("mineBlock() hashes = %v, want %v", stats.Hashes, want)
+	}
+}
+
+func Test_mineBlockEndToEnd(t *testing.T) {
+	txData := "0100000000000000000000"
+	template := Block{
+		// Regtest genesis block
+		PreviousBlockHash: "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+		Bits:              "207fffff",
+		CurTime:           1296688700,
+		Height:            1,
+		Version:           0x20000000,
+		CoinBaseValue:     5000000000,
+		Transactions:      []Transaction{{Data: txData, Hash: computeTxHashString(txData)}},
+	}
+	target, _ := btclib.DecodeTargetBits(template.Bits)
+
+	block, mined, _, err := mineBlock(template, time.Time{})
+	if err != nil {
+		t.Fatal("mineBlock() error:", err)
+	}
+	if !mined {
+		t.Fatal("mineBlock() did not solve a regtest difficulty block")
+	}
+	if !validateSolvedBlock(block) {
+		t.Error("validateSolvedBlock() rejected the mined block")
+	}
+
+	subm := makeBlockSubmission(block)
+
+	// The submitted header hashes to the reported block hash within target
+	header := btclib.HexToBin(subm[:160])
+	headerHash := computeBlockHeaderHash(header)
+	if got := btclib.BinToHex(headerHash); got != block.Hash {
+		t.Errorf("submitted header hash = %s, want %s", got, block.Hash)
+	}
+	if !checkBlockTarget(headerHash, target) {
+		t.Error("submitted header hash does not reach the target")
+	}
+
+	// The transactions follow the header and match its merkle root
+	coinbase := block.Transactions[0]
+	if got, want := subm[160:], "02"+coinbase.Data+txData; got != want {
+		t.Errorf("submitted transactions = %s, want %s", got, want)
+	}
+	if got := computeTxHashString(coinbase.Data); got != coinbase.Hash {
+		t.Errorf("coinbase hash = %s, want %s", got, coinbase.Hash)
+	}
+	merkleRoot := btclib.ComputeMerkleRoot(
+		[]string{coinbase.Hash, template.Transactions[0].Hash})
+	if !reflect.DeepEqual(header[36:68], merkleRoot) {
+		t.Errorf("header merkle root = %x, want %x", header[36:68], merkleRoot)
+	}
+}
+
+func Test_mineBlockLitecoin(t *testing.T) {
+	defer func(currency string) { miningCurrency = currency }(miningCurrency)
+	miningCurrency = ltc
+
+	txData := "0100000000000000000000"
+	template := Block{
+		PreviousBlockHash: "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+		Bits:              "207fffff",
+		CurTime:           1296688700,
+		Height:            1,
+		Version:           0x20000000,
+		CoinBaseValue:     5000000000,
+		Transactions:      []Transaction{{Data: txData, Hash: computeTxHashString(txData)}},
+	}
+
+	block, mined, _, err := mineBlock(template, time.Time{})
+	if err != nil {
+		t.Fatal("mineBlock() error:", err)
+	}
+	if !mined {
+		t.Fatal("mineBlock() did not solve a regtest difficulty block")
+	}
+
+	// Litecoin hashes transactions and merkle nodes with double SHA-256,
+	// only the header with scrypt
+	coinbase := block.Transactions[0]
+	coinbaseHash := btclib.DoubleSHA256(btclib.HexToBin(coinbase.Data))
+	if got, want := coinbase.Hash, btclib.BinToHex(btclib.ReverseBytes(
+		append([]byte{}, coinbaseHash...))); got != want {
+		t.Errorf("coinbase hash = %s, want %s", got, want)
+	}
+	txHash := btclib.DoubleSHA256(btclib.HexToBin(txData))
+	merkleRoot := btclib.DoubleSHA256(append(coinbaseHash, txHash...))
+	header := makeHeader(block)
+	if !reflect.DeepEqual(header[36:68], merkleRoot) {
+		t.Errorf("header merkle root = %x, want %x", header[36:68], merkleRoot)
+	}
+	if got, want := block.Hash, btclib.BinToHex(btclib.ReverseBytes(
+		computeLTCHash(header))); got != want {
+		t.Errorf("block hash = %s, want scrypt hash %s", got, want)
+	}
+	if !validateSolvedBlock(block) {
+		t.Error("validateSolvedBlock() rejected the mined block")
+	}
+}
+
+func Test_makeBlockSubmissionKnownBlock(t *testing.T) {
+	// Bitcoin block 1, a real mined block with a single coinbase transaction
+	coinbaseData := "01000000" + "01" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"ffffffff" + "07" + "04ffff001d0104" + "ffffffff" + "01" +
+		"00f2052a01000000" + "43" +
+		"410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c" +
+		"52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac" +
+		"00000000"
+	coinbaseHash := "0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098"
+	wantHash := "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048"
+	wantHeader := "01000000" +
+		"6fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d6190000000000" +
+		"982051fd1e4ba744bbbe680e1fee14677ba1a3c3540bf7b1cdb606e857233e0e" +
+		"61bc6649" + "ffff001d" + "01e36299"
+
+	if got := computeTxHashString(coinbaseData); got != coinbaseHash {
+		t.Fatalf("computeTxHashString() = %v, want %v", got, coinbaseHash)
+	}
+	block := Block{
+		Version:           1,
+		PreviousBlockHash: "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		CurTime:           1231469665,
+		Bits:              "1d00ffff",
+		Nonce:             2573394689,
+		Transactions:      []Transaction{{Data: coinbaseData, Hash: coinbaseHash}},
+	}
+	block.MerkleRoot = btclib.ComputeMerkleRoot([]string{coinbaseHash})
+
+	subm := makeBlockSubmission(block)
+	if want := wantHeader + "01" + coinbaseData; subm != want {
+		t.Fatalf("makeBlockSubmission() = %v, want %v", subm, want)
+	}
+	headerHash := computeBlockHeaderHash(btclib.HexToBin(subm[:160]))
+	if got := btclib.BinToHex(headerHash); got != wantHash {
+		t.Errorf("computeBlockHeaderHash() = %v, want %v", got, wantHash)
+	}
+	target, _ := btclib.DecodeTargetBits(block.Bits)
+	if !checkBlockTarget(headerHash, target) {
+		t.Error("checkBlockTarget() = false, want true")
+	}
+}
+
+// Bitcoin genesis block with its coinbase transaction
+func genesisBlock() Block {
+	return Block{
+		Version:           1,
+		PreviousBlockHash: "0000000000000000000000000000000000000000000000000000000000000000",
+		MerkleRoot: btclib.ReverseBytes(btclib.HexToBin(
+			"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")),
+		CurTime: 1231006505,
+		Bits:    "1d00ffff",
+		Nonce:   2083236893,
+		Transactions: []Transaction{{Data: "01000000" + "01" +
+			"0000000000000000000000000000000000000000000000000000000000000000" +
+			"ffffffff" + "4d" +
+			"04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368" +
+			"616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c" +
+			"6f757420666f722062616e6b73" +
+			"ffffffff" + "01" + "00f2052a01000000" + "43" +
+			"4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61de" +
+			"b649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac" +
+			"00000000"}},
+	}
+}
+
+func Test_validateSolvedBlock(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(b *Block)
+		want   bool
+	}{
+		{"genesis", func(b *Block) {}, true},
+		{"wrong nonce", func(b *Block) { b.Nonce++ }, false},
+		{"transactions not matching the merkle root", func(b *Block) {
+			b.Transactions = []Transaction{{Data: "01000000"}}
+		}, false},
+		{"no transactions", func(b *Block) { b.Transactions = nil }, false},
+		{"zero target bits", func(b *Block) { b.Bits = "1d000000" }, false},
+		{"negative target bits", func(b *Block) { b.Bits = "1d80ffff" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := genesisBlock()
+			tt.modify(&b)
+			if got := validateSolvedBlock(b); got != tt.want {
+				t.Errorf("validateSolvedBlock() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_compactToTarget(t *testing.T) {
 	tests := []struct {
-		x    uint64
+		bits string
 		want string
 	}{
-		{0x1a, "1a"},
-		{0x1a2b, "fd2b1a"},
-		{0x1a2b3c, "fe3c2b1a00"},
-		{0x1a2b3c4d, "fe4d3c2b1a"},
-		{0x1a2b3c4d5e, "ff5e4d3c2b1a000000"},
+		{"1d00ffff", "00000000ffff0000000000000000000000000000000000000000000000000000"},
+		{"207fffff", "7fffff0000000000000000000000000000000000000000000000000000000000"},
+		{"03000001", "0000000000000000000000000000000000000000000000000000000000000001"},
+		{"0200ff00", "00000000000000000000000000000000000000000000000000000000000000ff"},
+		{"03000000", ""},
+		{"01003456", ""},
+		{"1d800000", ""},
+		{"21ffffff", ""},
+		{"1d00ff", ""},
 	}
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("_%d", i), func(t *testing.T) {
-			if got := uintToVarIntHex(tt.x); got != tt.want {
-				t.Errorf("uintToVarIntHex() = %v, want %v", got, tt.want)
+			if got := btclib.BinToHex(compactToTarget(tt.bits)); got != tt.want {
+				t.Errorf("compactToTarget() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func Test_makeCoinBaseTx(t *testing.T) {
-	want := "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff2503ef98030400001059124d696e656420627920425443204775696c640800000037000011caffffffff01a0635c95000000001976a91427a1f12771de5cc3b73941664b2537c15316be4388ac00000000"
+func Test_submitSolvedBlockValidatorMismatch(t *testing.T) {
+	defer func(validator func([]byte, []byte) bool) {
+		blockValidator = validator
+	}(blockValidator)
+	called := false
+	blockValidator = func(header []byte, target []byte) bool {
+		called = true
+		return false
+	}
 
-	coinbaseScript := "03ef98030400001059124d696e656420627920425443204775696c640800000037000011ca"
-	address := "14cZMQk89mRYQkDEj8Rn25AnGoBi5H6uer"
-	value := uint64(2505860000)
+	submitted, err := submitSolvedBlock(genesisBlock())
+	if err != nil {
+		t.Fatal("submitSolvedBlock() error:", err)
+	}
+	if !called {
+		t.Error("submitSolvedBlock() did not call blockValidator")
+	}
+	if submitted {
+		t.Error("submitSolvedBlock() submitted a block rejected by blockValidator")
+	}
+}
 
-	got := makeCoinBaseTx(coinbaseScript, address, value, 0)
+func Fuzz_validateBlockTemplate(f *testing.F) {
+	f.Add("0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206", "207fffff",
+		"aef812ae5d301be4bad82cd1881a7e0d735e081cb91f725c1fbc1ece83aba23c", "01000000")
+	f.Add("0f", "207fff", "aef8", "0")
+	f.Add("0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206", "21ffffff",
+		"aef812ae5d301be4bad82cd1881a7e0d735e081cb91f725c1fbc1ece83aba23c", "01000000")
+	f.Fuzz(func(t *testing.T, prevHash, bits, txHash, txData string) {
+		b := Block{
+			PreviousBlockHash: prevHash,
+			Bits:              bits,
+			Transactions:      []Transaction{{Hash: txHash, Data: txData}},
+		}
+		if err := validateBlockTemplate(b); err != nil {
+			return
+		}
+		// A template passing validation must have a target some hash can
+		// reach and a header that can be built from it
+		target, _ := btclib.DecodeTargetBits(bits)
+		if new(big.Int).SetBytes(target).Sign() == 0 {
+			t.Errorf("validateBlockTemplate() accepted bits %q with a zero target", bits)
+		}
+		b.MerkleRoot = btclib.ComputeMerkleRoot([]string{txHash})
+		if header := makeHeader(b); len(header) != 80 {
+			t.Errorf("makeHeader() returned %d bytes", len(header))
+		}
+	})
+}
 
-	if want != got {
-		t.Log("want:", want)
-		t.Log(" got:", got)
-		t.Fatal("want not equal to got")
+func Test_isDuplicateSubmitResult(t *testing.T) {
+	tests := []struct {
+		result string
+		want   bool
+	}{
+		{"duplicate", true},
+		{"duplicate-invalid", true},
+		{"duplicate-inconclusive", true},
+		{"inconclusive", false},
+		{"high-hash", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.result, func(t *testing.T) {
+			if got := isDuplicateSubmitResult(tt.result); got != tt.want {
+				t.Errorf("isDuplicateSubmitResult() = %v, want %v", got, tt.want)
+			}
+		})
 	}
 }
 
-func Test_computeMerkleRoot(t *testing.T) {
-	type args struct {
-		txHashes []string
-	}
+func Test_submitResultError(t *testing.T) {
 	tests := []struct {
-		name string
-		args args
-		want string
+		result        string
+		wantDuplicate bool
 	}{
-		{ // block testnet 00000000000001624cce24d8b32cb09ad5432a7173b905a06d048547f241a0b0
-			name: "even hashes count",
-			args: args{
-				txHashes: []string{
-					"decb39cfcc1d6b8e0155d14b8923dfc1b6cfe65bcc19a9f9e136b7a65c2ffb9d",
-					"3e52c0e53c2c12b8d51cae5700273273f3968e7d9edc2c6e1093a74ba6fe7865",
-					"0f7035239f5bd6759b5bfdf8f7cbfce0e7b382e105fa55af20a3994f439524c0",
-					"d07fb5461d4a455270c06a6370708f6282256c2608a1937e54cd5db1f272657d",
-				},
-			},
-			want: "07ecfbfa6214b1261daf058dbd226091d26acf8511c88f21b660a901cbc8179b",
-		},
-		{ // block testnet 00000000000000be13b52a46edd0e959e7785d569feb1b42ffc6eee7ae7caafa
-			name: "odd hashes count",
-			args: args{
-				txHashes: []string{
-					"1f4f9136b20069249115d55c843ec18acc1889b862cde134386e14396de7bdb3",
-					"d731233d670af128e0a77dae8aa2b998f7515d0febe9aeb3ddfca0fff256e5b1",
-					"874afe1c0e0dd76fcd4cd4f4a923a803d119e8612c70e6599c4e9cd98bb54084",
-				},
-			},
-			want: "1489b66849671c4758d2b90d411e64b8e7ea5681ace1f60b9e053b94c7aef231",
-		},
-		{ // block regtest 63b2a02cce7888f359c82413f66de5cd4ad109fb91be7a19493df62551491975
-			name: "single transaction",
-			args: args{
-				txHashes: []string{
-					"aef812ae5d301be4bad82cd1881a7e0d735e081cb91f725c1fbc1ece83aba23c",
-				},
-			},
-			want: "aef812ae5d301be4bad82cd1881a7e0d735e081cb91f725c1fbc1ece83aba23c",
-		},
+		{"duplicate", true},
+		{"duplicate-invalid", true},
+		{"high-hash", false},
+		{"bad-txnmrklroot", false},
+		{"inconclusive", false},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := computeMerkleRoot(tt.args.txHashes); binToHex(reverseBytes(got)) != tt.want {
-				t.Errorf("computeMerkleRoot() = %v, want %v", binToHex(reverseBytes(got)), tt.want)
+		t.Run(tt.result, func(t *testing.T) {
+			err := submitResultError(tt.result)
+			if err == nil {
+				t.Fatal("submitResultError() should reject a non-null result")
+			}
+			if got := errors.Is(err, errDuplicateBlock); got != tt.wantDuplicate {
+				t.Errorf("submitResultError() duplicate = %v, want %v", got, tt.wantDuplicate)
 			}
 		})
 	}